@@ -19,13 +19,16 @@ package images
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	"github.com/containers/image/v5/copy"
 	"github.com/containers/image/v5/docker"
-	"github.com/containers/image/v5/signature"
 	"github.com/containers/image/v5/transports/alltransports"
+	"github.com/opencontainers/go-digest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // 定义结构体来匹配 JSON 结构
@@ -38,6 +41,18 @@ type ImageManifest struct {
 type CopyImageOptions struct {
 	srcImage  *srcImageOptions
 	destImage *destImageOptions
+
+	// CopyAllPlatforms 为 true 时，镜像索引下的全部子 manifest 都会被镜像，
+	// 而不是只挑选与目标架构匹配的那一个。
+	CopyAllPlatforms bool
+
+	// SignaturePolicy 控制 Copy 在写入目标仓库前如何校验来源镜像的签名，
+	// 必须设置；为 nil 时 Copy 会直接报错，而不是默默信任任何签名。
+	SignaturePolicy *SignaturePolicy
+
+	// Progress 接收 Copy 过程中的进度输出，为 nil 时沿用历史上直接打印到
+	// os.Stdout 的行为。
+	Progress ProgressReporter
 }
 
 type ManifestEntry struct {
@@ -54,32 +69,50 @@ type PlatformDetails struct {
 }
 
 func (c *CopyImageOptions) Check() (bool, error) {
-	srcContext := c.srcImage.systemContext()
-	srcRef, err := alltransports.ParseImageName(c.srcImage.imageName)
-	if err != nil {
-		return false, err
-	}
 	ctx := context.Background()
 
-	src, err := srcRef.NewImageSource(ctx, srcContext)
+	manifestData, mimeType, err := c.fetchManifest(ctx)
 	if err != nil {
 		return false, err
 	}
 
-	// 获取镜像的清单数据和 MIME 类型
-	manifestData, _, err := src.GetManifest(ctx, nil)
-	if err != nil {
-		return false, err
+	return c.checkManifestData(ctx, manifestData, mimeType)
+}
+
+// checkManifestData implements Check's verdict for already-fetched manifest
+// data. Factored out of Check so the CopyAllPlatforms/manifest-list decision
+// can be unit tested without a real registry round trip.
+func (c *CopyImageOptions) checkManifestData(ctx context.Context, manifestData []byte, mimeType string) (bool, error) {
+	// 较老的 Harbor / registry:2 部署仍然可能返回 Docker schema 1（含签名）
+	// 清单，它的结构和 OCI/v2 完全不同，必须单独解析。
+	if isDockerSchema1(mimeType) {
+		arch, err := schema1Architecture(manifestData)
+		if err != nil {
+			return false, err
+		}
+		return arch == c.destImage.dockerImage.arch, nil
 	}
 
 	// 解析 JSON 数据
 	var manifest ImageManifest
-	if err := json.Unmarshal([]byte(string(manifestData)), &manifest); err != nil {
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
 		return false, err
 	}
 
-	// 获取并打印每个 manifest 的 architecture 值
-	if manifest.Manifests == nil {
+	switch {
+	case manifest.Manifests != nil && c.CopyAllPlatforms:
+		// CopyAllPlatforms 时 Copy 会把整份索引搬过去，不需要目标架构出现在
+		// 索引里——和 Copy 里 CopyAllPlatforms 分支的判断保持一致。
+		return true, nil
+
+	case manifest.Manifests != nil:
+		// manifest list（多架构镜像）：逐个子 manifest 比对目标平台，而不是直接放行
+		if _, ok := c.matchingManifest(manifest); !ok {
+			return false, fmt.Errorf("image %s has no manifest for platform %s", c.srcImage.imageName, platformString(c.destImage.dockerImage.os, c.destImage.dockerImage.arch, c.destImage.dockerImage.variant))
+		}
+		return true, nil
+
+	case manifest.SchemaVersion == 2:
 		// 解析镜像引用
 		ref, err := docker.ParseReference("//" + strings.Split(c.srcImage.imageName, "//")[1])
 		if err != nil {
@@ -87,7 +120,7 @@ func (c *CopyImageOptions) Check() (bool, error) {
 		}
 
 		// 获取镜像的详细信息
-		img, err := ref.NewImage(ctx, srcContext)
+		img, err := ref.NewImage(ctx, c.srcImage.systemContext())
 		if err != nil {
 			return false, err
 		}
@@ -99,15 +132,63 @@ func (c *CopyImageOptions) Check() (bool, error) {
 			return false, err
 		}
 
-		if inspectedImage.Architecture != c.destImage.dockerImage.arch {
-			return false, nil
+		return inspectedImage.Architecture == c.destImage.dockerImage.arch, nil
+
+	default:
+		return false, fmt.Errorf("image %s has unrecognized manifest schema version %d (mime type %q)", c.srcImage.imageName, manifest.SchemaVersion, mimeType)
+	}
+}
+
+// fetchManifest 解析源镜像引用并获取它的原始 manifest 数据和 MIME 类型；
+// Check 和 sourceManifestList 都建立在这一次读取之上，避免各自重复整套
+// parse-image-name/NewImageSource/GetManifest 流程。
+func (c *CopyImageOptions) fetchManifest(ctx context.Context) ([]byte, string, error) {
+	srcRef, err := alltransports.ParseImageName(c.srcImage.imageName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	src, err := srcRef.NewImageSource(ctx, c.srcImage.systemContext())
+	if err != nil {
+		return nil, "", err
+	}
+	defer src.Close()
+
+	return src.GetManifest(ctx, nil)
+}
+
+// matchingManifest 在一个 manifest list 中查找与目标镜像平台（架构、操作系统、
+// ARM 变体）一致的子 manifest。
+func (c *CopyImageOptions) matchingManifest(manifest ImageManifest) (ManifestEntry, bool) {
+	want := c.destImage.dockerImage
+	for _, entry := range manifest.Manifests {
+		if platformMatches(entry.Platform, want.arch, want.os, want.variant) {
+			return entry, true
 		}
 	}
+	return ManifestEntry{}, false
+}
 
-	return true, nil
+// platformMatches 比较一个 manifest list 条目的平台和请求的架构/操作系统/
+// ARM 变体是否一致。os 和 variant 为空字符串时视为通配，这样即便调用方不
+// 关心操作系统或变体，也仍然能单靠架构来缩小范围。
+func platformMatches(platform PlatformDetails, arch, os, variant string) bool {
+	if platform.Architecture != arch {
+		return false
+	}
+	if os != "" && platform.OS != os {
+		return false
+	}
+	if variant != "" && platform.Variant != variant {
+		return false
+	}
+	return true
 }
-func (c *CopyImageOptions) Copy() error {
-	policyContext, err := getPolicyContext()
+
+// Copy 把源镜像搬运到目标仓库。ctx 会一路传给底层的 copy.Image 调用，取消
+// ctx 能中断一次正在进行中的拷贝，而不仅仅是阻止下一次重试开始。
+func (c *CopyImageOptions) Copy(ctx context.Context) error {
+	policyContext, err := c.SignaturePolicy.policyContext()
 	if err != nil {
 		return err
 	}
@@ -125,30 +206,126 @@ func (c *CopyImageOptions) Copy() error {
 	srcContext := c.srcImage.systemContext()
 	destContext := c.destImage.systemContext()
 
-	_, err = copy.Image(context.Background(), policyContext, destRef, srcRef, &copy.Options{
-		ReportWriter:   os.Stdout,
+	opts := &copy.Options{
+		ReportWriter:   c.reportWriter(),
 		SourceCtx:      srcContext,
 		DestinationCtx: destContext,
-	})
+	}
+
+	switch manifestList, hasList, err := c.sourceManifestList(ctx); {
+	case err != nil:
+		return err
+	case c.CopyAllPlatforms:
+		// 镜像索引的全部子镜像都需要，交给 copy 库按索引整体搬运
+		opts.ImageListSelection = copy.CopyAllImages
+	case hasList:
+		entry, ok := c.matchingManifest(manifestList)
+		if !ok {
+			return fmt.Errorf("image %s has no manifest for platform %s", c.srcImage.imageName, platformString(c.destImage.dockerImage.os, c.destImage.dockerImage.arch, c.destImage.dockerImage.variant))
+		}
+		// 只搬运与目标架构匹配的那一个子 manifest，避免把整份索引都拉下来
+		narrowedSrcContext := *srcContext
+		narrowedSrcContext.ArchitectureChoice = entry.Platform.Architecture
+		narrowedSrcContext.OSChoice = entry.Platform.OS
+		narrowedSrcContext.VariantChoice = entry.Platform.Variant
+		opts.SourceCtx = &narrowedSrcContext
+		opts.ImageListSelection = copy.CopySpecificImages
+	}
+
+	_, err = copy.Image(ctx, policyContext, destRef, srcRef, opts)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func getPolicyContext() (*signature.PolicyContext, error) {
-	policy := &signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}}
-	return signature.NewPolicyContext(policy)
+// sourceManifestList 读取源镜像的 manifest，如果它是一个 manifest list（多架构
+// 镜像索引）则返回解析结果，否则第二个返回值为 false。
+func (c *CopyImageOptions) sourceManifestList(ctx context.Context) (ImageManifest, bool, error) {
+	manifestData, _, err := c.fetchManifest(ctx)
+	if err != nil {
+		return ImageManifest{}, false, err
+	}
+
+	var manifest ImageManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return ImageManifest{}, false, err
+	}
+
+	return manifest, manifest.Manifests != nil, nil
+}
+
+// SourceDigest 返回 fetchManifest 取得的源镜像 manifest 的内容摘要。Mirror 的
+// Journal 用它而不是目标字符串给进度记录加索引，这样上游把同一个 tag 重新
+// 指向新内容之后，旧的 Journal 记录不会被误当成依然有效。
+func (c *CopyImageOptions) SourceDigest(ctx context.Context) (string, error) {
+	manifestData, _, err := c.fetchManifest(ctx)
+	if err != nil {
+		return "", err
+	}
+	return digest.FromBytes(manifestData).String(), nil
+}
+
+// DestinationHasDigest 报告目标仓库当前是否已经存有与 wantDigest 一致的
+// manifest。Mirror 在信任 Journal 的"已完成"记录之前用它核实目标仓库的真实
+// 状态——目标仓库可能在 Journal 文件之外被清空或重建。目标引用尚不存在时
+// 视为"没有"而不是报错。
+func (c *CopyImageOptions) DestinationHasDigest(ctx context.Context, wantDigest string) (bool, error) {
+	destRef, err := alltransports.ParseImageName(c.destImage.imageName)
+	if err != nil {
+		return false, err
+	}
+
+	destSrc, err := destRef.NewImageSource(ctx, c.destImage.systemContext())
+	if err != nil {
+		return false, nil
+	}
+	defer destSrc.Close()
+
+	manifestData, _, err := destSrc.GetManifest(ctx, nil)
+	if err != nil {
+		return false, nil
+	}
+
+	return digest.FromBytes(manifestData).String() == wantDigest, nil
+}
+
+// reportWriter 返回 Copy 进度输出的目标，未设置 Progress 时保持历史上直接
+// 写到标准输出的行为。
+func (c *CopyImageOptions) reportWriter() io.Writer {
+	if c.Progress != nil {
+		return c.Progress
+	}
+	return os.Stdout
 }
 
+// platformString 格式化一个 os/arch[/variant] 三元组，用于错误信息展示。
+func platformString(os, arch, variant string) string {
+	platform := os + "/" + arch
+	if variant != "" {
+		platform += "/" + variant
+	}
+	return platform
+}
+
+// Index is a pulled-image inventory: the set of manifests that were mirrored
+// together, recorded so it can be stored and retrieved as a first-class
+// custom resource via AddToScheme.
+//
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 type Index struct {
-	Manifests []Manifest
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Manifests []Manifest `json:"manifests"`
 }
 
+// +k8s:deepcopy-gen=true
 type Manifest struct {
-	Annotations annotations
+	Annotations annotations `json:"annotations"`
 }
 
+// +k8s:deepcopy-gen=true
 type annotations struct {
 	RefName string `json:"org.opencontainers.image.ref.name"`
 }
@@ -0,0 +1,118 @@
+/*
+ Copyright 2022 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package images
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPlatformMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		platform PlatformDetails
+		arch     string
+		os       string
+		variant  string
+		want     bool
+	}{
+		{
+			name:     "exact match",
+			platform: PlatformDetails{Architecture: "arm", OS: "linux", Variant: "v7"},
+			arch:     "arm",
+			os:       "linux",
+			variant:  "v7",
+			want:     true,
+		},
+		{
+			name:     "architecture mismatch",
+			platform: PlatformDetails{Architecture: "arm64", OS: "linux"},
+			arch:     "amd64",
+			os:       "linux",
+			want:     false,
+		},
+		{
+			name:     "os mismatch",
+			platform: PlatformDetails{Architecture: "amd64", OS: "windows"},
+			arch:     "amd64",
+			os:       "linux",
+			want:     false,
+		},
+		{
+			name:     "variant mismatch",
+			platform: PlatformDetails{Architecture: "arm", OS: "linux", Variant: "v6"},
+			arch:     "arm",
+			os:       "linux",
+			variant:  "v7",
+			want:     false,
+		},
+		{
+			name:     "empty os wildcard still matches on architecture",
+			platform: PlatformDetails{Architecture: "amd64", OS: "windows"},
+			arch:     "amd64",
+			want:     true,
+		},
+		{
+			name:     "empty variant wildcard matches regardless of entry variant",
+			platform: PlatformDetails{Architecture: "arm", OS: "linux", Variant: "v8"},
+			arch:     "arm",
+			os:       "linux",
+			want:     true,
+		},
+		{
+			name:     "requested variant but entry has none",
+			platform: PlatformDetails{Architecture: "arm", OS: "linux"},
+			arch:     "arm",
+			os:       "linux",
+			variant:  "v7",
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := platformMatches(tt.platform, tt.arch, tt.os, tt.variant); got != tt.want {
+				t.Errorf("platformMatches(%+v, %q, %q, %q) = %v, want %v", tt.platform, tt.arch, tt.os, tt.variant, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCheckManifestListWithCopyAllPlatforms covers the combination Check
+// falls over for without CopyAllPlatforms: a manifest list whose children
+// don't include the destination's own arch. Copy would still succeed here
+// (CopyAllPlatforms mirrors the whole index), so Check must agree instead of
+// failing a caller that gates Copy on it.
+func TestCheckManifestListWithCopyAllPlatforms(t *testing.T) {
+	manifestData := []byte(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+		"manifests": [
+			{"mediaType": "application/vnd.docker.distribution.manifest.v2+json", "digest": "sha256:aaaa", "size": 1, "platform": {"architecture": "arm64", "os": "linux"}}
+		]
+	}`)
+
+	c := &CopyImageOptions{CopyAllPlatforms: true}
+
+	ok, err := c.checkManifestData(context.Background(), manifestData, "application/vnd.docker.distribution.manifest.list.v2+json")
+	if err != nil {
+		t.Fatalf("checkManifestData() error = %v, want nil", err)
+	}
+	if !ok {
+		t.Error("checkManifestData() = false, want true: CopyAllPlatforms mirrors the whole index regardless of the destination's arch")
+	}
+}
@@ -0,0 +1,316 @@
+/*
+ Copyright 2022 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package images
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgressReporter 接收 Copy 在镜像单个镜像时产生的进度输出，取代过去写死
+// 的 os.Stdout，调用方可以据此把进度接入自己的 UI 或日志系统。
+type ProgressReporter interface {
+	io.Writer
+}
+
+const defaultBackoff = time.Second
+
+// MirrorImage 描述一对需要镜像的源、目标镜像引用。
+type MirrorImage struct {
+	Src  string
+	Dest string
+}
+
+// MirrorResult 记录单个镜像的镜像结果。
+type MirrorResult struct {
+	Image    MirrorImage
+	Skipped  bool
+	Attempts int
+	Err      error
+}
+
+// Journal 记录哪些目标镜像已经被成功镜像过，使中断后的 Mirror 调用可以跳过
+// 已经完成的部分，而不是每次都从头重新搬运所有镜像。记录以 digest（源镜像
+// 的内容摘要）而不仅仅是 dest 字符串为准，这样上游把同一个 tag 重新指向新
+// 内容之后，Done 不会把旧摘要误判为这次要搬运的内容已经完成。
+type Journal interface {
+	// Done 报告 dest 是否已经被记录为镜像完成了 digest 对应的内容。
+	Done(dest, digest string) (bool, error)
+	// MarkDone 把 dest 记录为已经镜像完成了 digest 对应的内容。
+	MarkDone(dest, digest string) error
+}
+
+// MirrorOptions 配置 Mirror 的并发度、重试策略、进度上报和断点续传行为。
+type MirrorOptions struct {
+	// Concurrency 控制同时进行的镜像拷贝数量，小于等于 0 时视为 1。
+	Concurrency int
+
+	// MaxRetries 是单个镜像在放弃前的最大重试次数，小于 0 视为 0（不重试）。
+	MaxRetries int
+
+	// Backoff 是第一次重试前的等待时间，此后每次重试翻倍；零值使用
+	// defaultBackoff。
+	Backoff time.Duration
+
+	// Progress 接收每个镜像拷贝过程中的进度输出。
+	Progress ProgressReporter
+
+	// Journal 为 nil 时不做断点续传，每次调用都会重新镜像全部镜像。
+	Journal Journal
+
+	// SignaturePolicy 应用到 Mirror 创建的每一个 CopyImageOptions。
+	SignaturePolicy *SignaturePolicy
+
+	// CopyAllPlatforms 应用到 Mirror 创建的每一个 CopyImageOptions。
+	CopyAllPlatforms bool
+
+	// NewCopyOptions 为每一对 (src, dest) 构造底层的 CopyImageOptions，必须
+	// 设置；srcImageOptions/destImageOptions 的字段未导出，Mirror 自己无法
+	// 构造它们。测试可以传入一个返回假 CopyImageOptions 的函数。
+	NewCopyOptions func(src, dest string) (*CopyImageOptions, error)
+
+	// SourceDigest 解析 copyOpts 对应源镜像当前的内容摘要，供 Journal 按内容
+	// 索引用。为 nil 时默认调用 copyOpts.SourceDigest；测试可以换成不需要真实
+	// registry 往返的假实现。
+	SourceDigest func(ctx context.Context, copyOpts *CopyImageOptions) (string, error)
+
+	// DestinationHasDigest 报告目标仓库当前是否已经存有 digest 对应的
+	// manifest，用来在信任 Journal 的"已完成"记录之前核实目标仓库的真实状态。
+	// 为 nil 时默认调用 copyOpts.DestinationHasDigest；测试可以换成不需要真实
+	// registry 往返的假实现。
+	DestinationHasDigest func(ctx context.Context, copyOpts *CopyImageOptions, digest string) (bool, error)
+}
+
+// Mirror 用有限并发的 worker 池把一组镜像从源仓库搬运到目标仓库。瞬时性的
+// registry 错误（429、5xx、连接被提前关闭）会按指数退避重试；其余错误直接
+// 记录在对应的 MirrorResult 里，不影响其他镜像继续进行。真实的离线安装一次
+// 要推送数百个镜像，这里的并发与重试让它在不可靠网络下也能跑完。ctx 会一路
+// 传给底层的 CopyImageOptions.Copy，取消 ctx 既能让还没开始的重试提前退出，
+// 也能中断一次正在进行中的拷贝。
+func Mirror(ctx context.Context, images []MirrorImage, opts MirrorOptions) []MirrorResult {
+	if opts.NewCopyOptions == nil {
+		results := make([]MirrorResult, len(images))
+		for i, image := range images {
+			results[i] = MirrorResult{Image: image, Err: errors.New("MirrorOptions.NewCopyOptions must be set")}
+		}
+		return results
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultBackoff
+	}
+	if opts.SourceDigest == nil {
+		opts.SourceDigest = func(ctx context.Context, copyOpts *CopyImageOptions) (string, error) {
+			return copyOpts.SourceDigest(ctx)
+		}
+	}
+	if opts.DestinationHasDigest == nil {
+		opts.DestinationHasDigest = func(ctx context.Context, copyOpts *CopyImageOptions, digest string) (bool, error) {
+			return copyOpts.DestinationHasDigest(ctx, digest)
+		}
+	}
+
+	results := make([]MirrorResult, len(images))
+	work := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range work {
+				results[idx] = mirrorOne(ctx, images[idx], opts)
+			}
+		}()
+	}
+
+	for idx := range images {
+		work <- idx
+	}
+	close(work)
+	wg.Wait()
+
+	return results
+}
+
+// mirrorOne 镜像单个镜像。Journal 非 nil 时先解析源镜像当前的内容摘要，只有
+// Journal 记录这个摘要已经完成、并且目标仓库里确实还存有对应内容时才跳过——
+// 单纯一个 "dest 已经在某次历史运行中完成过" 的记录不足为信：上游可能已经把
+// 同一个 tag 重新指向了新内容，目标仓库也可能在 Journal 文件之外被清空或重
+// 建。失败时按 opts.Backoff 指数退避重试，直到成功、遇到不可重试的错误或
+// 达到 MaxRetries。
+func mirrorOne(ctx context.Context, image MirrorImage, opts MirrorOptions) MirrorResult {
+	result := MirrorResult{Image: image}
+
+	copyOpts, err := opts.NewCopyOptions(image.Src, image.Dest)
+	if err != nil {
+		result.Err = fmt.Errorf("preparing copy for %s -> %s: %w", image.Src, image.Dest, err)
+		return result
+	}
+	copyOpts.SignaturePolicy = opts.SignaturePolicy
+	copyOpts.CopyAllPlatforms = opts.CopyAllPlatforms
+	copyOpts.Progress = opts.Progress
+
+	var digest string
+	if opts.Journal != nil {
+		digest, err = opts.SourceDigest(ctx, copyOpts)
+		if err != nil {
+			result.Err = fmt.Errorf("resolving source digest for %s: %w", image.Src, err)
+			return result
+		}
+
+		done, err := opts.Journal.Done(image.Dest, digest)
+		if err != nil {
+			result.Err = fmt.Errorf("checking mirror journal for %s: %w", image.Dest, err)
+			return result
+		}
+		if done {
+			present, err := opts.DestinationHasDigest(ctx, copyOpts, digest)
+			if err != nil {
+				result.Err = fmt.Errorf("verifying destination %s still has %s: %w", image.Dest, digest, err)
+				return result
+			}
+			if present {
+				result.Skipped = true
+				return result
+			}
+			// Journal 说这个 digest 之前镜像过，但目标仓库里已经没有了（被清
+			// 空/重建，或者这个 dest 在 Journal 文件之外被别的流程改动过）——
+			// 继续往下真正镜像一遍。
+		}
+	}
+
+	return retryCopy(ctx, image, opts, digest, func() error { return copyOpts.Copy(ctx) }, result)
+}
+
+// retryCopy runs copyFn (normally CopyImageOptions.Copy bound to ctx) with
+// exponential backoff on transient registry errors, recording digest against
+// image.Dest in opts.Journal once it succeeds. Factored out of mirrorOne so
+// the retry/backoff/journal bookkeeping can be unit tested without a real
+// CopyImageOptions.
+func retryCopy(ctx context.Context, image MirrorImage, opts MirrorOptions, digest string, copyFn func() error, result MirrorResult) MirrorResult {
+	backoff := opts.Backoff
+	for attempt := 0; ; attempt++ {
+		result.Attempts = attempt + 1
+
+		copyErr := copyFn()
+		if copyErr == nil {
+			if opts.Journal != nil {
+				if err := opts.Journal.MarkDone(image.Dest, digest); err != nil {
+					result.Err = fmt.Errorf("recording mirror journal for %s: %w", image.Dest, err)
+				}
+			}
+			return result
+		}
+
+		if attempt >= opts.MaxRetries || !isTransientRegistryError(copyErr) {
+			result.Err = copyErr
+			return result
+		}
+
+		select {
+		case <-ctx.Done():
+			result.Err = ctx.Err()
+			return result
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// isTransientRegistryError 判断一个 Copy 错误是否值得重试：registry 返回的
+// 429/5xx，或者连接在传输中被提前关闭。
+func isTransientRegistryError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	// 前置一个空格，使以状态码开头的错误信息（如 "503 Service Unavailable"）
+	// 也能匹配到 " 503"。
+	msg := " " + err.Error()
+	for _, code := range []string{" 429", " 500", " 502", " 503", " 504"} {
+		if strings.Contains(msg, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// FileJournal 是一个用单个 JSON 文件持久化已完成镜像的 Journal 实现，文件
+// 内容是目标镜像引用到其已完成镜像的内容摘要的映射，可以在进程重启后继续
+// 读取。
+type FileJournal struct {
+	path string
+
+	mu   sync.Mutex
+	done map[string]string
+}
+
+// NewFileJournal 打开（或在不存在时准备创建）path 处的 journal 文件。
+func NewFileJournal(path string) (*FileJournal, error) {
+	j := &FileJournal{path: path, done: map[string]string{}}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return j, nil
+	case err != nil:
+		return nil, fmt.Errorf("reading mirror journal %s: %w", path, err)
+	}
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &j.done); err != nil {
+			return nil, fmt.Errorf("parsing mirror journal %s: %w", path, err)
+		}
+	}
+	return j, nil
+}
+
+// Done 实现 Journal：只有记录的摘要和 digest 一致时才算完成，这样上游把
+// dest 对应的 tag 重新指向新内容之后，旧摘要不会被误判为这次要搬运的内容
+// 已经完成。
+func (j *FileJournal) Done(dest, digest string) (bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return digest != "" && j.done[dest] == digest, nil
+}
+
+// MarkDone 实现 Journal，每次调用都会把完整的 journal 重新写回磁盘，保证
+// 进程在任意一次 MarkDone 之后被杀掉都不会丢失之前记录的进度。
+func (j *FileJournal) MarkDone(dest, digest string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.done[dest] = digest
+
+	data, err := json.MarshalIndent(j.done, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(j.path, data, 0o644)
+}
@@ -0,0 +1,336 @@
+/*
+ Copyright 2022 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package images
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIsTransientRegistryError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil error", nil, false},
+		{"wrapped EOF", fmt.Errorf("reading body: %w", io.EOF), true},
+		{"deadline exceeded", fmt.Errorf("request: %w", context.DeadlineExceeded), true},
+		{"429 too many requests", errors.New("received status 429 Too Many Requests"), true},
+		{"503 service unavailable", errors.New("GET https://registry/v2/: 503 Service Unavailable"), true},
+		{"404 not found is not transient", errors.New("manifest unknown: 404 Not Found"), false},
+		{"generic error is not transient", errors.New("invalid reference format"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientRegistryError(tt.err); got != tt.want {
+				t.Errorf("isTransientRegistryError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// countingJournal is a Journal test double that records MarkDone calls and
+// lets a test pre-seed which (dest, digest) pairs are already done.
+type countingJournal struct {
+	mu        sync.Mutex
+	done      map[string]string
+	markCalls int
+}
+
+// newCountingJournal seeds the journal with dest->digest entries already
+// marked done.
+func newCountingJournal(doneDigest map[string]string) *countingJournal {
+	j := &countingJournal{done: map[string]string{}}
+	for dest, digest := range doneDigest {
+		j.done[dest] = digest
+	}
+	return j
+}
+
+func (j *countingJournal) Done(dest, digest string) (bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return digest != "" && j.done[dest] == digest, nil
+}
+
+func (j *countingJournal) MarkDone(dest, digest string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.done[dest] = digest
+	j.markCalls++
+	return nil
+}
+
+func TestRetryCopySucceedsAfterTransientErrors(t *testing.T) {
+	journal := newCountingJournal(nil)
+	image := MirrorImage{Src: "docker://example.com/src:v1", Dest: "docker://example.com/dst:v1"}
+	opts := MirrorOptions{MaxRetries: 5, Backoff: time.Millisecond, Journal: journal}
+	digest := "sha256:aaaa"
+
+	var calls int
+	copyFn := func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("503 Service Unavailable")
+		}
+		return nil
+	}
+
+	result := retryCopy(context.Background(), image, opts, digest, copyFn, MirrorResult{Image: image})
+
+	if result.Err != nil {
+		t.Fatalf("retryCopy() error = %v, want nil", result.Err)
+	}
+	if result.Attempts != 3 {
+		t.Errorf("retryCopy() Attempts = %d, want 3", result.Attempts)
+	}
+	if journal.markCalls != 1 {
+		t.Errorf("journal.markCalls = %d, want 1", journal.markCalls)
+	}
+	if journal.done[image.Dest] != digest {
+		t.Errorf("journal.done[%s] = %q, want %q", image.Dest, journal.done[image.Dest], digest)
+	}
+}
+
+func TestRetryCopyStopsAtMaxRetries(t *testing.T) {
+	image := MirrorImage{Src: "docker://example.com/src:v1", Dest: "docker://example.com/dst:v1"}
+	opts := MirrorOptions{MaxRetries: 2, Backoff: time.Millisecond}
+
+	var calls int
+	copyFn := func() error {
+		calls++
+		return errors.New("500 Internal Server Error")
+	}
+
+	result := retryCopy(context.Background(), image, opts, "sha256:aaaa", copyFn, MirrorResult{Image: image})
+
+	if result.Err == nil {
+		t.Fatal("retryCopy() error = nil, want an error after exhausting retries")
+	}
+	// MaxRetries=2 allows attempts 0, 1 and 2 (the initial try plus two retries).
+	if result.Attempts != 3 {
+		t.Errorf("retryCopy() Attempts = %d, want 3", result.Attempts)
+	}
+	if calls != 3 {
+		t.Errorf("copyFn called %d times, want 3", calls)
+	}
+}
+
+func TestRetryCopyDoesNotRetryNonTransientError(t *testing.T) {
+	image := MirrorImage{Src: "docker://example.com/src:v1", Dest: "docker://example.com/dst:v1"}
+	opts := MirrorOptions{MaxRetries: 5, Backoff: time.Millisecond}
+
+	var calls int
+	permanentErr := errors.New("invalid reference format")
+	copyFn := func() error {
+		calls++
+		return permanentErr
+	}
+
+	result := retryCopy(context.Background(), image, opts, "sha256:aaaa", copyFn, MirrorResult{Image: image})
+
+	if !errors.Is(result.Err, permanentErr) {
+		t.Errorf("retryCopy() error = %v, want %v", result.Err, permanentErr)
+	}
+	if calls != 1 {
+		t.Errorf("copyFn called %d times, want 1 (no retry for non-transient error)", calls)
+	}
+}
+
+func TestRetryCopyBackoffDoublesBetweenAttempts(t *testing.T) {
+	image := MirrorImage{Src: "docker://example.com/src:v1", Dest: "docker://example.com/dst:v1"}
+	opts := MirrorOptions{MaxRetries: 2, Backoff: 20 * time.Millisecond}
+
+	var calls int
+	copyFn := func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("503 Service Unavailable")
+		}
+		return nil
+	}
+
+	start := time.Now()
+	result := retryCopy(context.Background(), image, opts, "sha256:aaaa", copyFn, MirrorResult{Image: image})
+	elapsed := time.Since(start)
+
+	if result.Err != nil {
+		t.Fatalf("retryCopy() error = %v, want nil", result.Err)
+	}
+	// Two waits: opts.Backoff then 2*opts.Backoff, i.e. 20ms + 40ms = 60ms.
+	if elapsed < 60*time.Millisecond {
+		t.Errorf("retryCopy() took %v, want at least 60ms for doubling backoff", elapsed)
+	}
+}
+
+// fakeCopyOptsMirrorOpts builds a MirrorOptions whose NewCopyOptions always
+// returns a shared, otherwise-empty CopyImageOptions and whose SourceDigest/
+// DestinationHasDigest are swapped for fakes, so mirrorOne's journal logic
+// can be exercised without a real registry round trip.
+func fakeCopyOptsMirrorOpts(srcDigest string, destHasDigest bool) MirrorOptions {
+	return MirrorOptions{
+		NewCopyOptions: func(src, dest string) (*CopyImageOptions, error) {
+			return &CopyImageOptions{}, nil
+		},
+		SourceDigest: func(ctx context.Context, copyOpts *CopyImageOptions) (string, error) {
+			return srcDigest, nil
+		},
+		DestinationHasDigest: func(ctx context.Context, copyOpts *CopyImageOptions, digest string) (bool, error) {
+			return destHasDigest && digest == srcDigest, nil
+		},
+	}
+}
+
+func TestMirrorOneSkipsWhenJournalDigestStillAtDestination(t *testing.T) {
+	image := MirrorImage{Src: "docker://example.com/src:v1", Dest: "docker://example.com/dst:v1"}
+	digest := "sha256:aaaa"
+	journal := newCountingJournal(map[string]string{image.Dest: digest})
+
+	opts := fakeCopyOptsMirrorOpts(digest, true)
+	opts.Journal = journal
+
+	result := mirrorOne(context.Background(), image, opts)
+
+	if !result.Skipped {
+		t.Error("mirrorOne() Skipped = false, want true for a digest the journal and destination agree on")
+	}
+}
+
+func TestMirrorOneRemirrorsWhenUpstreamDigestChanged(t *testing.T) {
+	// The journal remembers mirroring an older digest, but src now resolves
+	// to a new one (e.g. upstream repointed the tag) -- Done must not match
+	// the stale entry, so mirrorOne has to attempt the copy again rather
+	// than skip. CopyImageOptions{}.Copy fails fast (no SignaturePolicy
+	// configured) without dialing a real registry, which is all this test
+	// needs: proof that the stale journal entry wasn't trusted.
+	image := MirrorImage{Src: "docker://example.com/src:v1", Dest: "docker://example.com/dst:v1"}
+	journal := newCountingJournal(map[string]string{image.Dest: "sha256:old"})
+
+	opts := fakeCopyOptsMirrorOpts("sha256:new", true)
+	opts.Journal = journal
+
+	result := mirrorOne(context.Background(), image, opts)
+
+	if result.Skipped {
+		t.Error("mirrorOne() Skipped = true, want false when the source digest no longer matches the journal entry")
+	}
+	if result.Err == nil {
+		t.Fatal("mirrorOne() error = nil, want the fail-fast error from Copy's missing SignaturePolicy")
+	}
+}
+
+func TestMirrorOneRemirrorsWhenDestinationMissingDigest(t *testing.T) {
+	// The journal says this digest was mirrored before, but the destination
+	// no longer has it (wiped/recreated outside of the journal file) -- the
+	// stale "done" entry must not be trusted.
+	image := MirrorImage{Src: "docker://example.com/src:v1", Dest: "docker://example.com/dst:v1"}
+	digest := "sha256:aaaa"
+	journal := newCountingJournal(map[string]string{image.Dest: digest})
+
+	opts := fakeCopyOptsMirrorOpts(digest, false)
+	opts.Journal = journal
+
+	result := mirrorOne(context.Background(), image, opts)
+
+	if result.Skipped {
+		t.Error("mirrorOne() Skipped = true, want false when the destination no longer has the journaled digest")
+	}
+}
+
+func TestMirrorFailsFastWithoutNewCopyOptions(t *testing.T) {
+	images := []MirrorImage{{Src: "docker://example.com/src:v1", Dest: "docker://example.com/dst:v1"}}
+
+	results := Mirror(context.Background(), images, MirrorOptions{})
+
+	if len(results) != 1 {
+		t.Fatalf("Mirror() returned %d results, want 1", len(results))
+	}
+	if results[0].Err == nil {
+		t.Fatal("Mirror() error = nil, want an error when NewCopyOptions is unset")
+	}
+}
+
+func TestNewFileJournalBootstrapsWhenFileMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.json")
+
+	journal, err := NewFileJournal(path)
+	if err != nil {
+		t.Fatalf("NewFileJournal() error = %v, want nil for a missing file", err)
+	}
+
+	done, err := journal.Done("docker://example.com/dst:v1", "sha256:aaaa")
+	if err != nil {
+		t.Fatalf("Done() error = %v, want nil", err)
+	}
+	if done {
+		t.Error("Done() = true, want false for a freshly bootstrapped journal")
+	}
+}
+
+func TestFileJournalRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.json")
+	dest := "docker://example.com/dst:v1"
+	digest := "sha256:aaaa"
+
+	journal, err := NewFileJournal(path)
+	if err != nil {
+		t.Fatalf("NewFileJournal() error = %v", err)
+	}
+
+	if err := journal.MarkDone(dest, digest); err != nil {
+		t.Fatalf("MarkDone() error = %v", err)
+	}
+
+	// Reopen from disk to make sure MarkDone actually persisted, not just
+	// updated the in-memory map of the same instance.
+	reopened, err := NewFileJournal(path)
+	if err != nil {
+		t.Fatalf("NewFileJournal() (reopen) error = %v", err)
+	}
+
+	done, err := reopened.Done(dest, digest)
+	if err != nil {
+		t.Fatalf("Done() error = %v", err)
+	}
+	if !done {
+		t.Error("Done() = false after reopening the journal, want true")
+	}
+
+	staleDone, err := reopened.Done(dest, "sha256:bbbb")
+	if err != nil {
+		t.Fatalf("Done() error = %v", err)
+	}
+	if staleDone {
+		t.Error("Done() = true for a digest that differs from what was marked done, want false")
+	}
+
+	otherDone, err := reopened.Done("docker://example.com/other:v1", digest)
+	if err != nil {
+		t.Fatalf("Done() error = %v", err)
+	}
+	if otherDone {
+		t.Error("Done() = true for a destination that was never marked done")
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ Copyright 2022 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package images
+
+import (
+	"fmt"
+
+	"github.com/containers/image/v5/signature"
+)
+
+// SignaturePolicy 描述 Copy 在把镜像写入目标仓库前如何校验来源镜像的签名。
+// 零值（以及 nil）不再等价于信任一切：调用方必须显式选择 PolicyFile、
+// SigstoreSigned、KeyedGPG 之一，或者显式设置 InsecureAcceptAnything 才能
+// 保留历史上"信任一切"的行为，否则 policyContext 直接报错。
+type SignaturePolicy struct {
+	// PolicyFile 是一个 containers/image 风格的 policy.json 路径，非空时
+	// SigstoreSigned 和 KeyedGPG 都会被忽略；InsecureAcceptAnything 为 true
+	// 时则连 PolicyFile 也不会被读取。
+	PolicyFile string
+
+	// SigstoreSigned 要求镜像带有 sigstore/cosign 签名。
+	SigstoreSigned *SigstoreSignedPolicy
+
+	// KeyedGPG 要求镜像带有可用指定公钥验证的 GPG 签名。
+	KeyedGPG *KeyedGPGPolicy
+
+	// InsecureAcceptAnything 保留迁移前"接受任何签名"的行为，只应该由
+	// --insecure-policy 这种显式选择信任的场景来设置。
+	InsecureAcceptAnything bool
+}
+
+// SigstoreSignedPolicy 配置 sigstore/cosign 签名校验：要求证书由 Fulcio
+// 签发，并要求 Rekor 透明日志中存在对应的 SET。containers/image 不接受没有
+// Rekor 公钥的 Fulcio 要求，因此 RekorPublicKeyFile 为空时 policyContext
+// 会报错。
+type SigstoreSignedPolicy struct {
+	FulcioCAFile       string
+	FulcioOIDCIssuer   string
+	FulcioSubjectEmail string
+
+	RekorPublicKeyFile string
+}
+
+// KeyedGPGPolicy 配置基于单个 GPG 公钥文件的签名校验。
+type KeyedGPGPolicy struct {
+	KeyFile string
+}
+
+// policyContext 依据 SignaturePolicy 构造一个 signature.PolicyContext，调用方
+// 用完后需要负责调用 Destroy()。
+func (p *SignaturePolicy) policyContext() (*signature.PolicyContext, error) {
+	if p == nil {
+		return nil, fmt.Errorf("no signature policy configured: set PolicyFile, SigstoreSigned, KeyedGPG or explicitly opt into InsecureAcceptAnything")
+	}
+
+	if p.InsecureAcceptAnything {
+		policy := &signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}}
+		return signature.NewPolicyContext(policy)
+	}
+
+	if p.PolicyFile != "" {
+		policy, err := signature.NewPolicyFromFile(p.PolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading signature policy %s: %w", p.PolicyFile, err)
+		}
+		return signature.NewPolicyContext(policy)
+	}
+
+	var requirements []signature.PolicyRequirement
+
+	if sig := p.SigstoreSigned; sig != nil {
+		req, err := sigstoreSignedRequirement(sig)
+		if err != nil {
+			return nil, err
+		}
+		requirements = append(requirements, req)
+	}
+
+	if gpg := p.KeyedGPG; gpg != nil {
+		req, err := signature.NewPRSignedByKeyPath(signature.SBKeyTypeGPGKeys, gpg.KeyFile, signature.NewPRMMatchRepository())
+		if err != nil {
+			return nil, fmt.Errorf("building GPG signature requirement: %w", err)
+		}
+		requirements = append(requirements, req)
+	}
+
+	if len(requirements) == 0 {
+		return nil, fmt.Errorf("no signature policy configured: set PolicyFile, SigstoreSigned, KeyedGPG or explicitly opt into InsecureAcceptAnything")
+	}
+
+	return signature.NewPolicyContext(&signature.Policy{Default: requirements})
+}
+
+// sigstoreSignedRequirement 把一个 SigstoreSignedPolicy 翻译成对应的
+// signature.PolicyRequirement，只信任与被校验的来源镜像仓库相匹配的签名
+// 镜像标识。
+func sigstoreSignedRequirement(p *SigstoreSignedPolicy) (signature.PolicyRequirement, error) {
+	fulcio, err := signature.NewPRSigstoreSignedFulcio(
+		signature.PRSigstoreSignedFulcioWithCAFile(p.FulcioCAFile),
+		signature.PRSigstoreSignedFulcioWithOIDCIssuer(p.FulcioOIDCIssuer),
+		signature.PRSigstoreSignedFulcioWithSubjectEmail(p.FulcioSubjectEmail),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("building Fulcio trust root: %w", err)
+	}
+
+	opts := []signature.PRSigstoreSignedOption{
+		signature.PRSigstoreSignedWithFulcio(fulcio),
+		signature.PRSigstoreSignedWithSignedIdentity(signature.NewPRMMatchRepository()),
+	}
+	if p.RekorPublicKeyFile != "" {
+		opts = append(opts, signature.PRSigstoreSignedWithRekorPublicKeyPath(p.RekorPublicKeyFile))
+	}
+
+	req, err := signature.NewPRSigstoreSigned(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("building sigstore signature requirement: %w", err)
+	}
+	return req, nil
+}
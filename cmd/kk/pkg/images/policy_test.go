@@ -0,0 +1,89 @@
+/*
+ Copyright 2022 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package images
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicyContextRejectsNilAndZeroValue(t *testing.T) {
+	var nilPolicy *SignaturePolicy
+	if _, err := nilPolicy.policyContext(); err == nil {
+		t.Error("policyContext() on a nil *SignaturePolicy returned no error, want one")
+	}
+
+	if _, err := (&SignaturePolicy{}).policyContext(); err == nil {
+		t.Error("policyContext() on a zero-value SignaturePolicy returned no error, want one")
+	}
+}
+
+func TestPolicyContextInsecureAcceptAnythingIsExplicitOptIn(t *testing.T) {
+	pc, err := (&SignaturePolicy{InsecureAcceptAnything: true}).policyContext()
+	if err != nil {
+		t.Fatalf("policyContext() error = %v, want nil", err)
+	}
+	defer pc.Destroy()
+}
+
+func TestPolicyContextPolicyFileTakesPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.json")
+	policyJSON := `{"default": [{"type": "insecureAcceptAnything"}]}`
+	if err := os.WriteFile(path, []byte(policyJSON), 0o644); err != nil {
+		t.Fatalf("writing policy file: %v", err)
+	}
+
+	// PolicyFile is set alongside an (invalid) SigstoreSigned config that
+	// would fail if policyContext ever tried to build it; PolicyFile must
+	// win and the invalid config must be ignored.
+	policy := &SignaturePolicy{
+		PolicyFile:     path,
+		SigstoreSigned: &SigstoreSignedPolicy{},
+	}
+
+	pc, err := policy.policyContext()
+	if err != nil {
+		t.Fatalf("policyContext() error = %v, want nil", err)
+	}
+	defer pc.Destroy()
+}
+
+func TestPolicyContextPolicyFileMissing(t *testing.T) {
+	policy := &SignaturePolicy{PolicyFile: filepath.Join(t.TempDir(), "does-not-exist.json")}
+
+	if _, err := policy.policyContext(); err == nil {
+		t.Error("policyContext() with a missing PolicyFile returned no error, want one")
+	}
+}
+
+func TestPolicyContextKeyedGPG(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key.gpg")
+	if err := os.WriteFile(keyFile, []byte("not a real key, just needs to exist"), 0o644); err != nil {
+		t.Fatalf("writing fake key file: %v", err)
+	}
+
+	policy := &SignaturePolicy{KeyedGPG: &KeyedGPGPolicy{KeyFile: keyFile}}
+
+	pc, err := policy.policyContext()
+	if err != nil {
+		t.Fatalf("policyContext() error = %v, want nil", err)
+	}
+	defer pc.Destroy()
+}
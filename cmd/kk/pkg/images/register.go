@@ -0,0 +1,46 @@
+/*
+ Copyright 2022 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// +k8s:deepcopy-gen=package
+// +groupName=images.kubekey.kubesphere.io
+
+package images
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupVersion is the group version used to register Index with a
+// runtime.Scheme.
+var GroupVersion = schema.GroupVersion{Group: "images.kubekey.kubesphere.io", Version: "v1alpha1"}
+
+// SchemeBuilder collects the functions that add types in this
+// package-version to a runtime.Scheme.
+var SchemeBuilder = &runtime.SchemeBuilder{}
+
+// AddToScheme adds Index to the given scheme, the same way callers register
+// the v1alpha1 Task/TaskList types.
+var AddToScheme = SchemeBuilder.AddToScheme
+
+func init() {
+	SchemeBuilder.Register(addKnownTypes)
+}
+
+func addKnownTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(GroupVersion, &Index{})
+	return nil
+}
@@ -0,0 +1,82 @@
+/*
+ Copyright 2022 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package images
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Docker Registry HTTP API v2 仍然允许返回的 schema 1 清单 MIME 类型，前者
+// 未签名，后者是 JWS 签名过的变体。
+const (
+	mediaTypeDockerSchema1Manifest       = "application/vnd.docker.distribution.manifest.v1+json"
+	mediaTypeDockerSchema1SignedManifest = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+)
+
+// schema1Manifest 对应 Docker schema 1 清单，只保留了提取平台信息所需的字段。
+type schema1Manifest struct {
+	SchemaVersion int                   `json:"schemaVersion"`
+	Architecture  string                `json:"architecture"`
+	History       []schema1HistoryEntry `json:"history"`
+}
+
+type schema1HistoryEntry struct {
+	V1Compatibility string `json:"v1Compatibility"`
+}
+
+// schema1V1Compatibility 是 history[i].v1Compatibility 里内嵌的 JSON 字符串，
+// 同样只保留了我们关心的字段。
+type schema1V1Compatibility struct {
+	Architecture string `json:"architecture"`
+}
+
+// isDockerSchema1 报告 mimeType 是否是 Docker schema 1（含签名变体）清单。
+func isDockerSchema1(mimeType string) bool {
+	switch mimeType {
+	case mediaTypeDockerSchema1Manifest, mediaTypeDockerSchema1SignedManifest:
+		return true
+	default:
+		return false
+	}
+}
+
+// schema1Architecture 从一个 schema 1 清单里提取 architecture。和 crane 的
+// desc.Schema1() 一样，优先读取 history[0]（最新一层）内嵌的 v1Compatibility
+// 配置，顶层的 architecture 字段只作为兜底。
+func schema1Architecture(manifestData []byte) (string, error) {
+	var manifest schema1Manifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return "", fmt.Errorf("parsing docker schema 1 manifest: %w", err)
+	}
+
+	if len(manifest.History) > 0 {
+		var v1 schema1V1Compatibility
+		if err := json.Unmarshal([]byte(manifest.History[0].V1Compatibility), &v1); err != nil {
+			return "", fmt.Errorf("parsing docker schema 1 v1Compatibility: %w", err)
+		}
+		if v1.Architecture != "" {
+			return v1.Architecture, nil
+		}
+	}
+
+	if manifest.Architecture != "" {
+		return manifest.Architecture, nil
+	}
+
+	return "", fmt.Errorf("docker schema 1 manifest has no architecture in history or top-level field")
+}
@@ -0,0 +1,104 @@
+/*
+ Copyright 2022 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package images
+
+import "testing"
+
+func TestIsDockerSchema1(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		want     bool
+	}{
+		{"application/vnd.docker.distribution.manifest.v1+json", true},
+		{"application/vnd.docker.distribution.manifest.v1+prettyjws", true},
+		{"application/vnd.docker.distribution.manifest.v2+json", false},
+		{"application/vnd.oci.image.index.v1+json", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isDockerSchema1(tt.mimeType); got != tt.want {
+			t.Errorf("isDockerSchema1(%q) = %v, want %v", tt.mimeType, got, tt.want)
+		}
+	}
+}
+
+func TestSchema1Architecture(t *testing.T) {
+	t.Run("reads architecture from history v1Compatibility", func(t *testing.T) {
+		manifest := []byte(`{
+			"schemaVersion": 1,
+			"architecture": "",
+			"history": [
+				{"v1Compatibility": "{\"architecture\":\"arm64\"}"}
+			]
+		}`)
+
+		got, err := schema1Architecture(manifest)
+		if err != nil {
+			t.Fatalf("schema1Architecture() error = %v", err)
+		}
+		if got != "arm64" {
+			t.Errorf("schema1Architecture() = %q, want %q", got, "arm64")
+		}
+	})
+
+	t.Run("falls back to top-level architecture when history is missing", func(t *testing.T) {
+		manifest := []byte(`{"schemaVersion": 1, "architecture": "amd64"}`)
+
+		got, err := schema1Architecture(manifest)
+		if err != nil {
+			t.Fatalf("schema1Architecture() error = %v", err)
+		}
+		if got != "amd64" {
+			t.Errorf("schema1Architecture() = %q, want %q", got, "amd64")
+		}
+	})
+
+	t.Run("history entry takes priority over top-level field", func(t *testing.T) {
+		manifest := []byte(`{
+			"schemaVersion": 1,
+			"architecture": "amd64",
+			"history": [
+				{"v1Compatibility": "{\"architecture\":\"arm\"}"}
+			]
+		}`)
+
+		got, err := schema1Architecture(manifest)
+		if err != nil {
+			t.Fatalf("schema1Architecture() error = %v", err)
+		}
+		if got != "arm" {
+			t.Errorf("schema1Architecture() = %q, want %q", got, "arm")
+		}
+	})
+
+	t.Run("errors when no architecture is found anywhere", func(t *testing.T) {
+		manifest := []byte(`{"schemaVersion": 1, "history": [{"v1Compatibility": "{}"}]}`)
+
+		if _, err := schema1Architecture(manifest); err == nil {
+			t.Fatal("schema1Architecture() error = nil, want an error")
+		}
+	})
+
+	t.Run("errors on malformed v1Compatibility", func(t *testing.T) {
+		manifest := []byte(`{"schemaVersion": 1, "history": [{"v1Compatibility": "not json"}]}`)
+
+		if _, err := schema1Architecture(manifest); err == nil {
+			t.Fatal("schema1Architecture() error = nil, want an error")
+		}
+	})
+}